@@ -0,0 +1,596 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/topology"
+)
+
+// NextHop related Gremlin tokens
+const (
+	nextHopTraversalToken traversal.Token = traversal.ExtensionID + iota
+	nextHopAllTraversalToken
+)
+
+// NextHop table/rule selector tokens
+const (
+	tableTraversalToken traversal.Token = pathToTraversalToken + iota + 1
+	fromTraversalToken
+	markTraversalToken
+	iifTraversalToken
+)
+
+// NextHopTraversalExtension adds the NextHop and NextHopPath (aliased as
+// PathTo) steps, along with the Table/From/Mark/Iif selectors, to the
+// Gremlin traversal language
+type NextHopTraversalExtension struct {
+	NextHopToken     traversal.Token
+	NextHopAllToken  traversal.Token
+	NextHopPathToken traversal.Token
+	PathToToken      traversal.Token
+	TableToken       traversal.Token
+	FromToken        traversal.Token
+	MarkToken        traversal.Token
+	IifToken         traversal.Token
+}
+
+// NextHopGremlinTraversalStep resolves, for each node of the previous step, the
+// next hop used to reach a given destination IP
+type NextHopGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	IPs     []string
+	Batch   bool
+	All     bool
+	Options nextHopOptions
+}
+
+// nextHopOptions narrows down which routing table NextHop resolves against:
+// an explicit table, or a source IP / incoming interface / fwmark to be
+// matched against the node's RoutingRules, mirroring "ip route get" / "ip
+// rule" semantics.
+type nextHopOptions struct {
+	table *int64
+	src   netip.Addr
+	mark  uint32
+	iif   int64
+}
+
+// nextHopOption is implemented by the value returned when parsing a
+// Table()/From()/Mark()/Iif() argument of a NextHop step
+type nextHopOption interface {
+	traversal.GremlinTraversalStep
+	apply(*nextHopOptions) error
+}
+
+// NewNextHopTraversalExtension returns a new graph traversal extension that
+// registers the NextHop Gremlin step
+func NewNextHopTraversalExtension() *NextHopTraversalExtension {
+	return &NextHopTraversalExtension{
+		NextHopToken:     nextHopTraversalToken,
+		NextHopAllToken:  nextHopAllTraversalToken,
+		NextHopPathToken: nextHopPathTraversalToken,
+		PathToToken:      pathToTraversalToken,
+		TableToken:       tableTraversalToken,
+		FromToken:        fromTraversalToken,
+		MarkToken:        markTraversalToken,
+		IifToken:         iifTraversalToken,
+	}
+}
+
+// ScopeIdentifier implements the GremlinTraversalExtension interface
+func (e *NextHopTraversalExtension) ScopeIdentifier() string { return "NextHop" }
+
+// ParseStep implements the GremlinTraversalExtension interface
+func (e *NextHopTraversalExtension) ParseStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	switch t {
+	case e.NextHopToken, e.NextHopAllToken:
+		if len(p.Params) == 0 {
+			return nil, fmt.Errorf("NextHop requires at least one parameter, the destination IP(s)")
+		}
+
+		ips, batch, err := parseDestinations(p.Params[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var options nextHopOptions
+		for _, param := range p.Params[1:] {
+			opt, ok := param.(nextHopOption)
+			if !ok {
+				return nil, fmt.Errorf("unsupported NextHop option: %v", param)
+			}
+			if err := opt.apply(&options); err != nil {
+				return nil, err
+			}
+		}
+
+		return &NextHopGremlinTraversalStep{
+			GremlinTraversalContext: p,
+			IPs:                     ips,
+			Batch:                   batch,
+			All:                     t == e.NextHopAllToken,
+			Options:                 options,
+		}, nil
+
+	case e.NextHopPathToken, e.PathToToken:
+		return e.parsePathStep(t, p)
+
+	case e.TableToken:
+		return e.parseTableStep(p)
+
+	case e.FromToken:
+		return e.parseFromStep(p)
+
+	case e.MarkToken:
+		return e.parseMarkStep(p)
+
+	case e.IifToken:
+		return e.parseIifStep(p)
+	}
+
+	return nil, nil
+}
+
+// parseDestinations turns a NextHop/NextHopAll first parameter into a list
+// of destination IPs, along with whether it was given as a single string
+// (false) or a list of destinations (true).
+func parseDestinations(param interface{}) ([]string, bool, error) {
+	switch v := param.(type) {
+	case string:
+		return []string{v}, false, nil
+	case []interface{}:
+		ips := make([]string, 0, len(v))
+		for _, e := range v {
+			ip, ok := e.(string)
+			if !ok {
+				return nil, false, fmt.Errorf("NextHop destination list entries have to be strings")
+			}
+			ips = append(ips, ip)
+		}
+		return ips, true, nil
+	default:
+		return nil, false, fmt.Errorf("NextHop first parameter has to be a string or a list of strings")
+	}
+}
+
+// Exec resolves the next hop(s) for every node returned by the previous step
+func (s *NextHopGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	dsts := make([]netip.Addr, len(s.IPs))
+	for i, ip := range s.IPs {
+		dst, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid IP address", ip)
+		}
+		dsts[i] = dst
+	}
+
+	nodes := make([]*graph.Node, 0, len(last.Values()))
+	for _, value := range last.Values() {
+		if node, ok := value.(*graph.Node); ok {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if s.All {
+		results := make(map[string]map[string]*NextHopResult)
+		for _, node := range nodes {
+			perDest := make(map[string]*NextHopResult)
+			for i, dst := range dsts {
+				route, table := resolveRoute(node, dst, s.Options)
+				if route == nil || len(route.NextHops) == 0 {
+					continue
+				}
+				perDest[s.IPs[i]] = &NextHopResult{
+					NextHop: route.NextHops[0],
+					Prefix:  route.Prefix,
+					Table:   table,
+				}
+			}
+			if len(perDest) > 0 {
+				results[string(node.ID)] = perDest
+			}
+		}
+		return &NextHopAllTraversalStep{results: results}, nil
+	}
+
+	if s.Batch {
+		nexthops := make(map[string]map[string]*topology.NextHop)
+		for _, node := range nodes {
+			perDest := make(map[string]*topology.NextHop)
+			for i, dst := range dsts {
+				if nh := resolveNextHop(node, dst, s.Options); nh != nil {
+					perDest[s.IPs[i]] = nh
+				}
+			}
+			if len(perDest) > 0 {
+				nexthops[string(node.ID)] = perDest
+			}
+		}
+		return &NextHopBatchTraversalStep{nexthops: nexthops}, nil
+	}
+
+	nexthops := make(map[string]*topology.NextHop)
+	for _, node := range nodes {
+		if nh := resolveNextHop(node, dsts[0], s.Options); nh != nil {
+			nexthops[string(node.ID)] = nh
+		}
+	}
+
+	return &NextHopTraversalStep{nexthops: nexthops}, nil
+}
+
+// Reduce implements the GremlinTraversalStep interface
+func (s *NextHopGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context returns the traversal context
+func (s *NextHopGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// NextHopTraversalStep is the result of a NextHop Gremlin step: one resolved
+// next hop per node ID
+type NextHopTraversalStep struct {
+	nexthops map[string]*topology.NextHop
+	error    error
+}
+
+// Values returns the single map[string]*topology.NextHop produced by the step
+func (s *NextHopTraversalStep) Values() []interface{} {
+	return []interface{}{s.nexthops}
+}
+
+// MarshalJSON marshals the step result
+func (s *NextHopTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.nexthops)
+}
+
+// Error returns the error encountered during the execution of the step, if any
+func (s *NextHopTraversalStep) Error() error {
+	return s.error
+}
+
+// NextHopBatchTraversalStep is the result of a NextHop Gremlin step given a
+// list of destinations: one resolved next hop per node ID, per destination IP
+type NextHopBatchTraversalStep struct {
+	nexthops map[string]map[string]*topology.NextHop
+	error    error
+}
+
+// Values returns the single map[string]map[string]*topology.NextHop produced by the step
+func (s *NextHopBatchTraversalStep) Values() []interface{} {
+	return []interface{}{s.nexthops}
+}
+
+// MarshalJSON marshals the step result
+func (s *NextHopBatchTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.nexthops)
+}
+
+// Error returns the error encountered during the execution of the step, if any
+func (s *NextHopBatchTraversalStep) Error() error {
+	return s.error
+}
+
+// NextHopResult is one entry of a NextHopAll result: the resolved next hop,
+// along with the route it was matched against, so that callers can tell a
+// specific match from a default-route fallback.
+//
+// easyjson:json
+// gendecoder
+type NextHopResult struct {
+	NextHop *topology.NextHop `json:"NextHop,omitempty"`
+	Prefix  topology.Prefix   `json:"Prefix"`
+	Table   int64             `json:"Table"`
+}
+
+// NextHopAllTraversalStep is the result of a NextHopAll Gremlin step: one
+// NextHopResult per node ID, per destination IP
+type NextHopAllTraversalStep struct {
+	results map[string]map[string]*NextHopResult
+	error   error
+}
+
+// Values returns the single map[string]map[string]*NextHopResult produced by the step
+func (s *NextHopAllTraversalStep) Values() []interface{} {
+	return []interface{}{s.results}
+}
+
+// MarshalJSON marshals the step result
+func (s *NextHopAllTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.results)
+}
+
+// Error returns the error encountered during the execution of the step, if any
+func (s *NextHopAllTraversalStep) Error() error {
+	return s.error
+}
+
+// routingTableNames maps the well-known Linux routing table names to their
+// numeric ID, as found in /etc/iproute2/rt_tables
+var routingTableNames = map[string]int64{
+	"default": 253,
+	"main":    254,
+	"local":   255,
+}
+
+// resolveNextHop looks up the next hop to reach dst on node, honouring an
+// explicit table selector or, failing that, the node's RoutingRules. With
+// neither, every RoutingTable on the node is searched and the most specific
+// match wins, preserving the historical, table-agnostic NextHop behaviour.
+func resolveNextHop(node *graph.Node, dst netip.Addr, opts nextHopOptions) *topology.NextHop {
+	route, _ := resolveRoute(node, dst, opts)
+	if route == nil || len(route.NextHops) == 0 {
+		return nil
+	}
+	return route.NextHops[0]
+}
+
+// resolveRoute looks up the route used to reach dst on node, honouring an
+// explicit table selector or, failing that, the node's RoutingRules. With
+// neither, every RoutingTable on the node is searched and the most specific
+// match wins, preserving the historical, table-agnostic NextHop behaviour;
+// ties between equally specific matches in different tables are broken by
+// ascending table ID, since map iteration order is randomized. It also
+// returns the ID of the table the route was matched in.
+func resolveRoute(node *graph.Node, dst netip.Addr, opts nextHopOptions) (*topology.Route, int64) {
+	tries := getNodeTries(node)
+	if tries == nil {
+		return nil, 0
+	}
+
+	if id, ok := selectTable(node, opts); ok {
+		trie := tries[id]
+		if trie == nil {
+			return nil, 0
+		}
+		_, route := trie.lookupRoute(dst)
+		return route, id
+	}
+
+	ids := make([]int64, 0, len(tries))
+	for id := range tries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var best *topology.Route
+	var bestTable int64
+	bestDepth := -1
+	for _, id := range ids {
+		depth, route := tries[id].lookupRoute(dst)
+		if route != nil && depth > bestDepth {
+			best, bestTable, bestDepth = route, id, depth
+		}
+	}
+
+	return best, bestTable
+}
+
+// selectTable returns the ID of the routing table NextHop should be resolved
+// against, either because it was given explicitly or because a RoutingRule
+// matched opts. When a source, fwmark or incoming interface is given and the
+// node has RoutingRules but none of them matches, the main table is used. The
+// second return value is false when no table could be narrowed down (no
+// selector given, or no RoutingRules on the node), in which case every table
+// should be searched.
+func selectTable(node *graph.Node, opts nextHopOptions) (int64, bool) {
+	if opts.table != nil {
+		return *opts.table, true
+	}
+
+	if !opts.src.IsValid() && opts.mark == 0 && opts.iif == 0 {
+		return 0, false
+	}
+
+	rules, err := node.GetField("RoutingRules")
+	if err != nil {
+		return 0, false
+	}
+
+	list, ok := rules.(*topology.RoutingRules)
+	if !ok || list == nil {
+		return 0, false
+	}
+
+	sorted := append(topology.RoutingRules{}, *list...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	for _, rule := range sorted {
+		if ruleMatches(rule, opts) {
+			return rule.Table, true
+		}
+	}
+
+	// no rule matched, fall back to the main table
+	return routingTableNames["main"], true
+}
+
+// ruleMatches reports whether rule's selector matches opts, the same way
+// the kernel evaluates "ip rule" entries: every selector set on the rule
+// has to match, an unset selector is ignored.
+func ruleMatches(rule *topology.RoutingRule, opts nextHopOptions) bool {
+	if rule.Src != nil {
+		if !opts.src.IsValid() || !netip.Prefix(*rule.Src).Contains(opts.src) {
+			return false
+		}
+	}
+
+	if rule.FwMark != 0 && rule.FwMark != opts.mark {
+		return false
+	}
+
+	if rule.IifIndex != 0 && rule.IifIndex != opts.iif {
+		return false
+	}
+
+	return true
+}
+
+// nodeTrieCache caches, per node, the per-table binary tries built from
+// its RoutingTables metadata so that a full tree walk isn't needed on every
+// lookup. The cache is invalidated whenever the node's metadata revision
+// changes.
+var nodeTrieCache = struct {
+	sync.Mutex
+	entries map[graph.Identifier]*cachedTrie
+}{entries: make(map[graph.Identifier]*cachedTrie)}
+
+type cachedTrie struct {
+	revision int64
+	tries    map[int64]*routeTrie
+}
+
+func getNodeTries(node *graph.Node) map[int64]*routeTrie {
+	nodeTrieCache.Lock()
+	if entry, ok := nodeTrieCache.entries[node.ID]; ok && entry.revision == node.Revision {
+		nodeTrieCache.Unlock()
+		return entry.tries
+	}
+	nodeTrieCache.Unlock()
+
+	routingTables, err := node.GetField("RoutingTables")
+	if err != nil {
+		return nil
+	}
+
+	tables, ok := routingTables.(*topology.RoutingTables)
+	if !ok || tables == nil {
+		return nil
+	}
+
+	tries := make(map[int64]*routeTrie)
+	for _, table := range *tables {
+		trie := newRouteTrie()
+		for _, route := range table.Routes {
+			trie.insert(route)
+		}
+		tries[table.ID] = trie
+	}
+
+	nodeTrieCache.Lock()
+	nodeTrieCache.entries[node.ID] = &cachedTrie{revision: node.Revision, tries: tries}
+	nodeTrieCache.Unlock()
+
+	return tries
+}
+
+// routeTrie is a binary trie, one level per prefix bit and without path
+// compression, used to perform a longest prefix match lookup of an IP
+// destination against a set of routes. IPv4 and IPv6
+// prefixes are kept in separate tries, rooted at root4 and root6: sharing a
+// single trie would let an IPv6 default route (::/0, inserted at the root)
+// match an IPv4 lookup, returning an IPv6 next hop for an IPv4 destination.
+type routeTrie struct {
+	root4 *trieNode
+	root6 *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	route    *topology.Route
+}
+
+func newRouteTrie() *routeTrie {
+	return &routeTrie{root4: &trieNode{}, root6: &trieNode{}}
+}
+
+func bit(ip []byte, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+func (t *routeTrie) insert(route *topology.Route) {
+	prefix := netip.Prefix(route.Prefix)
+	if !prefix.IsValid() {
+		return
+	}
+	addr := prefix.Addr().Unmap()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4In6() {
+		bits -= 96
+	}
+
+	root := t.root6
+	ip := addr.AsSlice()
+	if addr.Is4() {
+		root = t.root4
+	}
+
+	n := root
+	for i := 0; i < bits; i++ {
+		b := bit(ip, i)
+		if n.children[b] == nil {
+			n.children[b] = &trieNode{}
+		}
+		n = n.children[b]
+	}
+	n.route = route
+}
+
+// lookupRoute returns the longest matching route for dst and the prefix
+// length (in trie bits) it was matched at, or (-1, nil) if no route, not
+// even a default one, matches.
+func (t *routeTrie) lookupRoute(dst netip.Addr) (int, *topology.Route) {
+	if !dst.IsValid() {
+		return -1, nil
+	}
+
+	dst = dst.Unmap()
+	root := t.root6
+	bits := 128
+	if dst.Is4() {
+		root = t.root4
+		bits = 32
+	}
+	ip := dst.AsSlice()
+
+	var best *topology.Route
+	depth := -1
+	n := root
+	if n.route != nil {
+		best, depth = n.route, 0
+	}
+
+	for i := 0; i < bits && n != nil; i++ {
+		n = n.children[bit(ip, i)]
+		if n != nil && n.route != nil {
+			best, depth = n.route, i+1
+		}
+	}
+
+	return depth, best
+}
+
+// lookup returns the NextHop of the longest matching route for dst, or nil
+// if no route (not even a default one) matches.
+func (t *routeTrie) lookup(dst netip.Addr) *topology.NextHop {
+	_, route := t.lookupRoute(dst)
+	if route == nil || len(route.NextHops) == 0 {
+		return nil
+	}
+	return route.NextHops[0]
+}
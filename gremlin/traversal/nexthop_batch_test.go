@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/topology"
+)
+
+func batchRoutingGraph(t *testing.T) (*graph.Graph, *graph.Node) {
+	g := newGraph(t)
+
+	specific := netip.MustParsePrefix("10.0.0.0/24")
+	specificNH := &topology.NextHop{IP: netip.MustParseAddr("192.168.1.1"), IfIndex: 1}
+	defaultNH := &topology.NextHop{IP: netip.MustParseAddr("192.168.1.254"), IfIndex: 1}
+
+	var routes []*topology.Route
+	routes = append(routes,
+		&topology.Route{Prefix: topology.Prefix(specific), NextHops: []*topology.NextHop{specificNH}},
+		&topology.Route{Prefix: topology.Prefix(topology.IPv4DefaultRoute), NextHops: []*topology.NextHop{defaultNH}},
+	)
+
+	var tables topology.RoutingTables
+	tables = append(tables, &topology.RoutingTable{ID: 254, Routes: routes})
+
+	n, _ := g.NewNode(graph.GenID(), graph.Metadata{"RoutingTables": &tables})
+	return g, n
+}
+
+/* NextHop given a list of destinations returns one next hop per destination */
+func TestNextHopStepBatch(t *testing.T) {
+	g, n := batchRoutingGraph(t)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop(['10.0.0.5', '8.8.8.8'])")
+	perDest := res.Values()[0].(map[string]map[string]*topology.NextHop)[string(n.ID)]
+
+	if nexthop := perDest["10.0.0.5"]; nexthop == nil || nexthop.IP.String() != "192.168.1.1" {
+		t.Fatalf("10.0.0.5 should resolve via the specific route, got: %v", nexthop)
+	}
+
+	if nexthop := perDest["8.8.8.8"]; nexthop == nil || nexthop.IP.String() != "192.168.1.254" {
+		t.Fatalf("8.8.8.8 should resolve via the default route, got: %v", nexthop)
+	}
+}
+
+/* NextHopAll reports the matched prefix and table alongside each next hop */
+func TestNextHopStepAll(t *testing.T) {
+	g, n := batchRoutingGraph(t)
+
+	res := execNextHopQuery(t, g, "g.v().NextHopAll(['10.0.0.5', '8.8.8.8'])")
+	perDest := res.Values()[0].(map[string]map[string]*NextHopResult)[string(n.ID)]
+
+	specific := perDest["10.0.0.5"]
+	if specific == nil || specific.NextHop.IP.String() != "192.168.1.1" {
+		t.Fatalf("10.0.0.5 should resolve via the specific route, got: %v", specific)
+	}
+	if specific.Table != 254 || netip.Prefix(specific.Prefix).String() != "10.0.0.0/24" {
+		t.Fatalf("10.0.0.5 should be matched against 10.0.0.0/24 in table 254, got: %v", specific)
+	}
+
+	def := perDest["8.8.8.8"]
+	if def == nil || def.NextHop.IP.String() != "192.168.1.254" {
+		t.Fatalf("8.8.8.8 should resolve via the default route, got: %v", def)
+	}
+	if def.Table != 254 || netip.Prefix(def.Prefix).String() != "0.0.0.0/0" {
+		t.Fatalf("8.8.8.8 should be matched against the default route in table 254, got: %v", def)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+)
+
+// tableGremlinTraversalStep carries the table ID or name given to a
+// Table(...) selector used as a NextHop argument
+type tableGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	id int64
+}
+
+func (e *NextHopTraversalExtension) parseTableStep(p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) != 1 {
+		return nil, fmt.Errorf("Table requires one parameter, the table ID or name")
+	}
+
+	switch v := p.Params[0].(type) {
+	case int64:
+		return &tableGremlinTraversalStep{GremlinTraversalContext: p, id: v}, nil
+	case string:
+		id, ok := routingTableNames[v]
+		if !ok {
+			return nil, fmt.Errorf("unknown routing table name: %s", v)
+		}
+		return &tableGremlinTraversalStep{GremlinTraversalContext: p, id: id}, nil
+	default:
+		return nil, fmt.Errorf("Table parameter has to be a number or a string")
+	}
+}
+
+func (s *tableGremlinTraversalStep) apply(opts *nextHopOptions) error {
+	id := s.id
+	opts.table = &id
+	return nil
+}
+
+func (s *tableGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	return last, nil
+}
+
+func (s *tableGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *tableGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// fromGremlinTraversalStep carries the source IP given to a From(...)
+// selector used as a NextHop argument
+type fromGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	ip netip.Addr
+}
+
+func (e *NextHopTraversalExtension) parseFromStep(p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) != 1 {
+		return nil, fmt.Errorf("From requires one parameter, the source IP")
+	}
+
+	s, ok := p.Params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("From parameter has to be a string")
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid IP address", s)
+	}
+
+	return &fromGremlinTraversalStep{GremlinTraversalContext: p, ip: ip}, nil
+}
+
+func (s *fromGremlinTraversalStep) apply(opts *nextHopOptions) error {
+	opts.src = s.ip
+	return nil
+}
+
+func (s *fromGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	return last, nil
+}
+
+func (s *fromGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *fromGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// markGremlinTraversalStep carries the fwmark given to a Mark(...) selector
+// used as a NextHop argument
+type markGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	mark uint32
+}
+
+func (e *NextHopTraversalExtension) parseMarkStep(p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) != 1 {
+		return nil, fmt.Errorf("Mark requires one parameter, the fwmark")
+	}
+
+	v, ok := p.Params[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("Mark parameter has to be a number")
+	}
+
+	return &markGremlinTraversalStep{GremlinTraversalContext: p, mark: uint32(v)}, nil
+}
+
+func (s *markGremlinTraversalStep) apply(opts *nextHopOptions) error {
+	opts.mark = s.mark
+	return nil
+}
+
+func (s *markGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	return last, nil
+}
+
+func (s *markGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *markGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// iifGremlinTraversalStep carries the incoming interface index given to an
+// Iif(...) selector used as a NextHop argument
+type iifGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	ifIndex int64
+}
+
+func (e *NextHopTraversalExtension) parseIifStep(p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) != 1 {
+		return nil, fmt.Errorf("Iif requires one parameter, the incoming interface index")
+	}
+
+	v, ok := p.Params[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("Iif parameter has to be a number")
+	}
+
+	return &iifGremlinTraversalStep{GremlinTraversalContext: p, ifIndex: v}, nil
+}
+
+func (s *iifGremlinTraversalStep) apply(opts *nextHopOptions) error {
+	opts.iif = s.ifIndex
+	return nil
+}
+
+func (s *iifGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	return last, nil
+}
+
+func (s *iifGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *iifGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/topology"
+)
+
+// NextHopPath related Gremlin tokens
+const (
+	nextHopPathTraversalToken traversal.Token = nextHopAllTraversalToken + iota + 1
+	pathToTraversalToken
+)
+
+// defaultNextHopPathTTL bounds the number of hops walked by NextHopPath
+// before giving up, the same way a traceroute caps the number of probes
+const defaultNextHopPathTTL = 30
+
+// Hop is one entry of a NextHopPath result: the node a packet traverses,
+// the next hop it was resolved to on that node, and the interface it
+// leaves through
+//
+// easyjson:json
+// gendecoder
+type Hop struct {
+	NodeID        graph.Identifier  `json:"NodeID"`
+	NextHop       *topology.NextHop `json:"NextHop,omitempty"`
+	EgressIfIndex int64             `json:"EgressIfIndex"`
+}
+
+// NextHopPathGremlinTraversalStep resolves, for each node of the previous
+// step, the ordered list of hops a packet takes to reach destIP
+type NextHopPathGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+	IP  string
+	TTL int
+}
+
+// parsePathStep parses a NextHopPath/PathTo step for ParseStep: the
+// destination IP and an optional TTL
+func (e *NextHopTraversalExtension) parsePathStep(t traversal.Token, p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) == 0 || len(p.Params) > 2 {
+		return nil, fmt.Errorf("NextHopPath requires the destination IP and an optional TTL")
+	}
+
+	ip, ok := p.Params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("NextHopPath first parameter has to be a string")
+	}
+
+	ttl := defaultNextHopPathTTL
+	if len(p.Params) == 2 {
+		n, ok := p.Params[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("NextHopPath TTL parameter has to be a number")
+		}
+		ttl = int(n)
+	}
+
+	return &NextHopPathGremlinTraversalStep{GremlinTraversalContext: p, IP: ip, TTL: ttl}, nil
+}
+
+// Exec walks the topology, node by node, resolving the next hop at each
+// step until destIP is directly connected, a loop is detected or TTL hops
+// have been walked
+func (s *NextHopPathGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	dst, err := netip.ParseAddr(s.IP)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid IP address", s.IP)
+	}
+
+	tv, ok := last.(*traversal.GraphTraversalV)
+	if !ok {
+		return nil, fmt.Errorf("NextHopPath must be applied to a list of nodes")
+	}
+	g := tv.GraphTraversal.Graph
+
+	paths := make(map[string][]Hop)
+	for _, value := range last.Values() {
+		node, ok := value.(*graph.Node)
+		if !ok {
+			continue
+		}
+
+		paths[string(node.ID)] = resolveNextHopPath(g, node, dst, s.TTL)
+	}
+
+	return &NextHopPathTraversalStep{paths: paths}, nil
+}
+
+// Reduce implements the GremlinTraversalStep interface
+func (s *NextHopPathGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+// Context returns the traversal context
+func (s *NextHopPathGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// NextHopPathTraversalStep is the result of a NextHopPath Gremlin step: one
+// ordered list of Hop per starting node ID
+type NextHopPathTraversalStep struct {
+	paths map[string][]Hop
+	error error
+}
+
+// Values returns the single map[string][]Hop produced by the step
+func (s *NextHopPathTraversalStep) Values() []interface{} {
+	return []interface{}{s.paths}
+}
+
+// MarshalJSON marshals the step result
+func (s *NextHopPathTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.paths)
+}
+
+// Error returns the error encountered during the execution of the step, if any
+func (s *NextHopPathTraversalStep) Error() error {
+	return s.error
+}
+
+// resolveNextHopPath walks the topology starting at node, following the
+// resolved next hop at each node to its neighbor graph node, until destIP
+// is directly reachable from the current node, a node already visited is
+// reached again (routing loop) or ttl hops have been walked.
+func resolveNextHopPath(g *graph.Graph, start *graph.Node, dst netip.Addr, ttl int) []Hop {
+	visited := make(map[graph.Identifier]bool)
+	node := start
+
+	var hops []Hop
+	for i := 0; i < ttl; i++ {
+		if visited[node.ID] {
+			break
+		}
+		visited[node.ID] = true
+
+		nh := resolveNextHop(node, dst, nextHopOptions{})
+		if nh == nil {
+			break
+		}
+
+		hops = append(hops, Hop{NodeID: node.ID, NextHop: nh, EgressIfIndex: nh.IfIndex})
+
+		if !nh.IP.IsValid() {
+			// directly connected, no further node to hop to
+			break
+		}
+
+		next := neighborNode(g, node, nh)
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	return hops
+}
+
+// neighborNode returns the graph node reachable through nh from node: the
+// Neighbor entry matching nh.IP gives the MAC address of the next hop,
+// which is then looked up among the nodes sharing an edge with node. Every
+// edge of node is considered, whatever its RelationType; edges are not
+// restricted to L2/L3 links.
+// If no Neighbor matched, nh.IP is instead looked up among each peer's own
+// IPV4/IPV6 addresses, as Interface nodes carry them.
+func neighborNode(g *graph.Graph, node *graph.Node, nh *topology.NextHop) *graph.Node {
+	mac := neighborMAC(node, nh.IP)
+	field := "IPV4"
+	if nh.IP.Is6() {
+		field = "IPV6"
+	}
+
+	for _, edge := range g.GetNodeEdges(node, graph.Metadata{}) {
+		peer := g.GetEdgeNode(edge, node)
+		if peer == nil {
+			continue
+		}
+
+		if mac != "" {
+			if peerMAC, _ := peer.GetFieldString("MAC"); peerMAC == mac {
+				return peer
+			}
+		}
+
+		for _, addr := range peerAddresses(peer, field) {
+			if prefix, err := netip.ParsePrefix(addr); err == nil && prefix.Addr() == nh.IP {
+				return peer
+			}
+		}
+	}
+
+	return nil
+}
+
+// peerAddresses returns the list of CIDR addresses stored in node's field
+// metadata (e.g. "IPV4"/"IPV6" on an Interface node), tolerating both the
+// []string a node is created with and the []interface{} it decodes to once
+// round-tripped through JSON.
+func peerAddresses(node *graph.Node, field string) []string {
+	v, err := node.GetField(field)
+	if err != nil {
+		return nil
+	}
+
+	switch addrs := v.(type) {
+	case []string:
+		return addrs
+	case []interface{}:
+		ips := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			if s, ok := addr.(string); ok {
+				ips = append(ips, s)
+			}
+		}
+		return ips
+	default:
+		return nil
+	}
+}
+
+// neighborMAC returns the MAC address associated with ip in node's Neighbors
+// metadata, or the empty string if none is found
+func neighborMAC(node *graph.Node, ip netip.Addr) string {
+	neighbors, err := node.GetField("Neighbors")
+	if err != nil {
+		return ""
+	}
+
+	list, ok := neighbors.(*topology.Neighbors)
+	if !ok || list == nil {
+		return ""
+	}
+
+	for _, neighbor := range *list {
+		if neighbor.IP == ip {
+			return neighbor.MAC
+		}
+	}
+
+	return ""
+}
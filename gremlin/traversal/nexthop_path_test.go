@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/topology"
+)
+
+/* two hops: gateway node resolves a next hop onto a directly connected neighbor */
+func TestNextHopPathStep1(t *testing.T) {
+	g := newGraph(t)
+
+	var neighborsA topology.Neighbors
+	neighborsA = append(neighborsA, &topology.Neighbor{
+		IP:      netip.MustParseAddr("10.0.0.2"),
+		IfIndex: 1,
+		MAC:     "aa:bb:cc:dd:ee:01",
+	})
+
+	var nhsA []*topology.NextHop
+	nhsA = append(nhsA, &topology.NextHop{IP: netip.MustParseAddr("10.0.0.2"), IfIndex: 1})
+	cidr := netip.MustParsePrefix("192.168.1.0/24")
+	var routesA []*topology.Route
+	routesA = append(routesA, &topology.Route{Prefix: topology.Prefix(cidr), NextHops: nhsA})
+	var tablesA topology.RoutingTables
+	tablesA = append(tablesA, &topology.RoutingTable{ID: 254, Routes: routesA})
+
+	a, _ := g.NewNode(graph.GenID(), graph.Metadata{
+		"Neighbors":     &neighborsA,
+		"RoutingTables": &tablesA,
+	})
+
+	var nhsB []*topology.NextHop
+	nhsB = append(nhsB, &topology.NextHop{IfIndex: 2})
+	var routesB []*topology.Route
+	routesB = append(routesB, &topology.Route{Prefix: topology.Prefix(cidr), NextHops: nhsB})
+	var tablesB topology.RoutingTables
+	tablesB = append(tablesB, &topology.RoutingTable{ID: 254, Routes: routesB})
+
+	b, _ := g.NewNode(graph.GenID(), graph.Metadata{
+		"MAC":           "aa:bb:cc:dd:ee:01",
+		"RoutingTables": &tablesB,
+	})
+
+	g.NewEdge(graph.GenID(), a, b, graph.Metadata{"RelationType": "layer2"})
+
+	res := execNextHopQuery(t, g, "g.v().Has('ID', '"+string(a.ID)+"').NextHopPath('192.168.1.5')")
+
+	paths, ok := res.Values()[0].(map[string][]Hop)
+	if !ok {
+		t.Fatalf("Unexpected result type: %v", res.Values())
+	}
+
+	hops, ok := paths[string(a.ID)]
+	if !ok {
+		t.Fatalf("Node entry not found")
+	}
+
+	if len(hops) != 2 {
+		t.Fatalf("Expected a 2 hop path, got: %v", hops)
+	}
+
+	if hops[0].NodeID != a.ID || hops[0].EgressIfIndex != 1 {
+		t.Fatalf("Unexpected first hop: %v", hops[0])
+	}
+
+	if hops[1].NodeID != b.ID || hops[1].EgressIfIndex != 2 {
+		t.Fatalf("Unexpected second hop: %v", hops[1])
+	}
+}
+
+/* two hops: no Neighbor/MAC entry for the next hop, so the peer is matched
+ * by its own IPV4 address instead */
+func TestNextHopPathStep2IPOnlyMatch(t *testing.T) {
+	g := newGraph(t)
+
+	var nhsA []*topology.NextHop
+	nhsA = append(nhsA, &topology.NextHop{IP: netip.MustParseAddr("10.0.0.2"), IfIndex: 1})
+	cidr := netip.MustParsePrefix("192.168.1.0/24")
+	var routesA []*topology.Route
+	routesA = append(routesA, &topology.Route{Prefix: topology.Prefix(cidr), NextHops: nhsA})
+	var tablesA topology.RoutingTables
+	tablesA = append(tablesA, &topology.RoutingTable{ID: 254, Routes: routesA})
+
+	a, _ := g.NewNode(graph.GenID(), graph.Metadata{
+		"RoutingTables": &tablesA,
+	})
+
+	var nhsB []*topology.NextHop
+	nhsB = append(nhsB, &topology.NextHop{IfIndex: 2})
+	var routesB []*topology.Route
+	routesB = append(routesB, &topology.Route{Prefix: topology.Prefix(cidr), NextHops: nhsB})
+	var tablesB topology.RoutingTables
+	tablesB = append(tablesB, &topology.RoutingTable{ID: 254, Routes: routesB})
+
+	b, _ := g.NewNode(graph.GenID(), graph.Metadata{
+		"IPV4":          []string{"10.0.0.2/24"},
+		"RoutingTables": &tablesB,
+	})
+
+	g.NewEdge(graph.GenID(), a, b, graph.Metadata{"RelationType": "layer2"})
+
+	res := execNextHopQuery(t, g, "g.v().Has('ID', '"+string(a.ID)+"').NextHopPath('192.168.1.5')")
+
+	paths, ok := res.Values()[0].(map[string][]Hop)
+	if !ok {
+		t.Fatalf("Unexpected result type: %v", res.Values())
+	}
+
+	hops, ok := paths[string(a.ID)]
+	if !ok {
+		t.Fatalf("Node entry not found")
+	}
+
+	if len(hops) != 2 {
+		t.Fatalf("Expected a 2 hop path, got: %v", hops)
+	}
+
+	if hops[1].NodeID != b.ID || hops[1].EgressIfIndex != 2 {
+		t.Fatalf("Unexpected second hop: %v", hops[1])
+	}
+}
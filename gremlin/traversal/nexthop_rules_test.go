@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/topology"
+)
+
+func twoTableGraph(t *testing.T) (*graph.Graph, *graph.Node) {
+	g := newGraph(t)
+
+	cidr := netip.MustParsePrefix("192.168.0.0/24")
+
+	nhMain := &topology.NextHop{IP: netip.MustParseAddr("10.0.0.1"), IfIndex: 2}
+	routesMain := []*topology.Route{{Prefix: topology.Prefix(cidr), NextHops: []*topology.NextHop{nhMain}}}
+
+	nhVRF := &topology.NextHop{IP: netip.MustParseAddr("10.0.0.2"), IfIndex: 3}
+	routesVRF := []*topology.Route{{Prefix: topology.Prefix(cidr), NextHops: []*topology.NextHop{nhVRF}}}
+
+	var tables topology.RoutingTables
+	tables = append(tables,
+		&topology.RoutingTable{ID: 254, Routes: routesMain},
+		&topology.RoutingTable{ID: 100, Routes: routesVRF},
+	)
+
+	n, _ := g.NewNode(graph.GenID(), graph.Metadata{"RoutingTables": &tables})
+	return g, n
+}
+
+/* explicit Table() selector picks the matching routing table */
+func TestNextHopStepTableSelector(t *testing.T) {
+	g, n := twoTableGraph(t)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Table(254))")
+	nexthop := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil || nexthop.IP.String() != "10.0.0.1" {
+		t.Fatalf("Table(254) should resolve via the main table, got: %v", nexthop)
+	}
+
+	res = execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Table(100))")
+	nexthop = res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil || nexthop.IP.String() != "10.0.0.2" {
+		t.Fatalf("Table(100) should resolve via table 100, got: %v", nexthop)
+	}
+}
+
+/* a RoutingRule selects table 100 for traffic sourced from 10.1.0.0/24 */
+func TestNextHopStepRoutingRule(t *testing.T) {
+	g, n := twoTableGraph(t)
+
+	srcCIDR := netip.MustParsePrefix("10.1.0.0/24")
+	srcPrefix := topology.Prefix(srcCIDR)
+
+	var rules topology.RoutingRules
+	rules = append(rules, &topology.RoutingRule{Priority: 100, Src: &srcPrefix, Table: 100})
+
+	n.Metadata["RoutingRules"] = &rules
+	g.SetMetadata(n, n.Metadata)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', From('10.1.0.5'))")
+	nexthop := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil || nexthop.IP.String() != "10.0.0.2" {
+		t.Fatalf("From('10.1.0.5') should be routed via table 100 by the rule, got: %v", nexthop)
+	}
+
+	res = execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', From('10.2.0.5'))")
+	nexthop = res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil {
+		t.Fatalf("From('10.2.0.5') should still resolve, falling through to the other table")
+	}
+}
+
+/* with no Table()/rule to narrow the search, a tie between two tables at the
+ * same prefix depth must be broken deterministically (lowest table ID wins),
+ * not by map iteration order */
+func TestNextHopStepNoSelectorTieBreak(t *testing.T) {
+	g, n := twoTableGraph(t)
+
+	for i := 0; i < 20; i++ {
+		res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5')")
+		nexthop := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+		if nexthop == nil || nexthop.IP.String() != "10.0.0.2" {
+			t.Fatalf("expected table 100 (lowest ID) to win the tie, got: %v", nexthop)
+		}
+	}
+}
+
+/* a RoutingRule selects table 100 for traffic received on interface 7 */
+func TestNextHopStepIifSelector(t *testing.T) {
+	g, n := twoTableGraph(t)
+
+	var rules topology.RoutingRules
+	rules = append(rules, &topology.RoutingRule{Priority: 100, IifIndex: 7, Table: 100})
+
+	n.Metadata["RoutingRules"] = &rules
+	g.SetMetadata(n, n.Metadata)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Iif(7))")
+	nexthop := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil || nexthop.IP.String() != "10.0.0.2" {
+		t.Fatalf("Iif(7) should be routed via table 100 by the rule, got: %v", nexthop)
+	}
+
+	res = execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Iif(8))")
+	nexthop = res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil {
+		t.Fatalf("Iif(8) should still resolve, falling through to the other table")
+	}
+}
+
+/* a RoutingRule selects table 100 for traffic marked with fwmark 256 */
+func TestNextHopStepMarkSelector(t *testing.T) {
+	g, n := twoTableGraph(t)
+
+	var rules topology.RoutingRules
+	rules = append(rules, &topology.RoutingRule{Priority: 100, FwMark: 256, Table: 100})
+
+	n.Metadata["RoutingRules"] = &rules
+	g.SetMetadata(n, n.Metadata)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Mark(256))")
+	nexthop := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil || nexthop.IP.String() != "10.0.0.2" {
+		t.Fatalf("Mark(256) should be routed via table 100 by the rule, got: %v", nexthop)
+	}
+
+	res = execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5', Mark(1))")
+	nexthop = res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if nexthop == nil {
+		t.Fatalf("Mark(1) should still resolve, falling through to the other table")
+	}
+}
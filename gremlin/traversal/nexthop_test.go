@@ -18,7 +18,7 @@
 package traversal
 
 import (
-	"net"
+	"net/netip"
 	"strings"
 	"testing"
 
@@ -50,7 +50,7 @@ func TestNextHopStep2(t *testing.T) {
 	g := newGraph(t)
 	var neighbors topology.Neighbors
 	neighbor := &topology.Neighbor{
-		IP:      net.ParseIP("10.16.0.2"),
+		IP:      netip.MustParseAddr("10.16.0.2"),
 		IfIndex: 2,
 		MAC:     "fa:16:3e:c1:e8:d1",
 	}
@@ -58,15 +58,15 @@ func TestNextHopStep2(t *testing.T) {
 
 	var nhs []*topology.NextHop
 	nh := &topology.NextHop{
-		IP:      net.ParseIP("10.16.0.2"),
+		IP:      netip.MustParseAddr("10.16.0.2"),
 		IfIndex: 2,
 	}
 	nhs = append(nhs, nh)
 
 	var routes []*topology.Route
-	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+	cidr := netip.MustParsePrefix("192.168.0.0/24")
 	route := &topology.Route{
-		Prefix:   topology.Prefix(*cidr),
+		Prefix:   topology.Prefix(cidr),
 		NextHops: nhs,
 	}
 	routes = append(routes, route)
@@ -105,7 +105,7 @@ func TestNextHopStep3(t *testing.T) {
 	g := newGraph(t)
 	var neighbors topology.Neighbors
 	neighbor := &topology.Neighbor{
-		IP:      net.ParseIP("10.16.0.12"),
+		IP:      netip.MustParseAddr("10.16.0.12"),
 		IfIndex: 2,
 		MAC:     "fa:16:3e:ce:e8:d1",
 	}
@@ -113,7 +113,7 @@ func TestNextHopStep3(t *testing.T) {
 
 	var nhs []*topology.NextHop
 	nh := &topology.NextHop{
-		IP:      net.ParseIP("10.16.0.12"),
+		IP:      netip.MustParseAddr("10.16.0.12"),
 		IfIndex: 2,
 	}
 	nhs = append(nhs, nh)
@@ -159,12 +159,12 @@ func TestNextHopStep4(t *testing.T) {
 	g := newGraph(t)
 	var neighbors topology.Neighbors
 	neighbor1 := &topology.Neighbor{
-		IP:      net.ParseIP("10.16.0.12"),
+		IP:      netip.MustParseAddr("10.16.0.12"),
 		IfIndex: 2,
 		MAC:     "fa:16:3e:ce:e8:d1",
 	}
 	neighbor2 := &topology.Neighbor{
-		IP:      net.ParseIP("192.64.0.1"),
+		IP:      netip.MustParseAddr("192.64.0.1"),
 		IfIndex: 2,
 		MAC:     "af:16:3e:de:e8:d3",
 	}
@@ -173,12 +173,12 @@ func TestNextHopStep4(t *testing.T) {
 
 	var nhs1 []*topology.NextHop
 	nhs1 = append(nhs1, &topology.NextHop{
-		IP:      net.ParseIP("10.16.0.12"),
+		IP:      netip.MustParseAddr("10.16.0.12"),
 		IfIndex: 2,
 	})
 	var nhs2 []*topology.NextHop
 	nhs2 = append(nhs2, &topology.NextHop{
-		IP:      net.ParseIP("192.64.0.1"),
+		IP:      netip.MustParseAddr("192.64.0.1"),
 		IfIndex: 2,
 	})
 
@@ -186,10 +186,10 @@ func TestNextHopStep4(t *testing.T) {
 	routes = append(routes, &topology.Route{
 		NextHops: nhs1,
 	})
-	_, cidr, _ := net.ParseCIDR("10.16.0.0/24")
+	cidr := netip.MustParsePrefix("10.16.0.0/24")
 	routes = append(routes, &topology.Route{
 		NextHops: nhs2,
-		Prefix:   topology.Prefix(*cidr),
+		Prefix:   topology.Prefix(cidr),
 	})
 
 	var routingtables topology.RoutingTables
@@ -230,10 +230,10 @@ func TestNextHopStep5(t *testing.T) {
 	})
 
 	var routes []*topology.Route
-	_, cidr, _ := net.ParseCIDR("10.60.0.0/24")
+	cidr := netip.MustParsePrefix("10.60.0.0/24")
 	routes = append(routes, &topology.Route{
 		NextHops: nhs,
-		Prefix:   topology.Prefix(*cidr),
+		Prefix:   topology.Prefix(cidr),
 	})
 
 	var routingtables topology.RoutingTables
@@ -258,10 +258,164 @@ func TestNextHopStep5(t *testing.T) {
 	if !ok {
 		t.Fatalf("Node entry not found")
 	}
-	if nexthop.IP == nil {
-		t.Fatal("IP should not be nil")
+	if nexthop.IP.IsValid() {
+		t.Fatal("IP should be the zero value for a directly connected next hop")
 	}
 	if nexthop.IfIndex != 5 {
 		t.Fatalf("Interface index not matching, got: %d", nexthop.IfIndex)
 	}
 }
+
+/* IPv6 equivalent of TestNextHopStep3/4: a specific route overrides the default route */
+func TestNextHopStep6IPv6(t *testing.T) {
+	g := newGraph(t)
+
+	var nhsDefault []*topology.NextHop
+	nhsDefault = append(nhsDefault, &topology.NextHop{
+		IP:      netip.MustParseAddr("2001:db8::1"),
+		IfIndex: 2,
+	})
+	var nhsSpecific []*topology.NextHop
+	nhsSpecific = append(nhsSpecific, &topology.NextHop{
+		IP:      netip.MustParseAddr("2001:db8::2"),
+		IfIndex: 3,
+	})
+
+	var routes []*topology.Route
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(topology.IPv6DefaultRoute),
+		NextHops: nhsDefault,
+	})
+	cidr := netip.MustParsePrefix("2001:db8::/32")
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(cidr),
+		NextHops: nhsSpecific,
+	})
+
+	var routingtables topology.RoutingTables
+	routingtables = append(routingtables, &topology.RoutingTable{
+		ID:     255,
+		Routes: routes,
+	})
+
+	m1 := graph.Metadata{
+		"RoutingTables": &routingtables,
+	}
+
+	n, _ := g.NewNode(graph.GenID(), m1)
+	res := execNextHopQuery(t, g, "g.v().NextHop('2001:db8::5')")
+
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 result, returned: %v", res.Values())
+	}
+
+	nexthops := res.Values()[0].(map[string]*topology.NextHop)
+	nexthop, ok := nexthops[string(n.ID)]
+	if !ok {
+		t.Fatalf("Node entry not found")
+	}
+	if nexthop.IP.String() != "2001:db8::2" {
+		t.Fatalf("IP not matching, got: %s", nexthop.IP)
+	}
+}
+
+/* Mixed dual-stack node: an IPv4 and an IPv6 lookup against the same routing table */
+func TestNextHopStep7DualStack(t *testing.T) {
+	g := newGraph(t)
+
+	var nhsV4 []*topology.NextHop
+	nhsV4 = append(nhsV4, &topology.NextHop{
+		IP:      netip.MustParseAddr("192.168.0.1"),
+		IfIndex: 2,
+	})
+	var nhsV6 []*topology.NextHop
+	nhsV6 = append(nhsV6, &topology.NextHop{
+		IP:      netip.MustParseAddr("2001:db8::1"),
+		IfIndex: 3,
+	})
+
+	var routes []*topology.Route
+	cidrV4 := netip.MustParsePrefix("192.168.0.0/24")
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(cidrV4),
+		NextHops: nhsV4,
+	})
+	cidrV6 := netip.MustParsePrefix("2001:db8::/32")
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(cidrV6),
+		NextHops: nhsV6,
+	})
+
+	var routingtables topology.RoutingTables
+	routingtables = append(routingtables, &topology.RoutingTable{
+		ID:     255,
+		Routes: routes,
+	})
+
+	m1 := graph.Metadata{
+		"RoutingTables": &routingtables,
+	}
+
+	n, _ := g.NewNode(graph.GenID(), m1)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('192.168.0.5')")
+	nexthop, ok := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if !ok || nexthop.IP.String() != "192.168.0.1" {
+		t.Fatalf("IPv4 lookup failed, got: %v", nexthop)
+	}
+
+	res = execNextHopQuery(t, g, "g.v().NextHop('2001:db8::5')")
+	nexthop, ok = res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]
+	if !ok || nexthop.IP.String() != "2001:db8::1" {
+		t.Fatalf("IPv6 lookup failed, got: %v", nexthop)
+	}
+}
+
+/* A table with an IPv6 default route but no IPv4 default route: an IPv4
+ * lookup must not fall through to the IPv6 default, since the two families
+ * are kept in separate tries. */
+func TestNextHopStep8SingleFamilyDefault(t *testing.T) {
+	g := newGraph(t)
+
+	var nhsV4 []*topology.NextHop
+	nhsV4 = append(nhsV4, &topology.NextHop{
+		IP:      netip.MustParseAddr("192.168.0.1"),
+		IfIndex: 2,
+	})
+	var nhsV6Default []*topology.NextHop
+	nhsV6Default = append(nhsV6Default, &topology.NextHop{
+		IP:      netip.MustParseAddr("2001:db8::1"),
+		IfIndex: 3,
+	})
+
+	var routes []*topology.Route
+	cidrV4 := netip.MustParsePrefix("192.168.0.0/24")
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(cidrV4),
+		NextHops: nhsV4,
+	})
+	routes = append(routes, &topology.Route{
+		Prefix:   topology.Prefix(topology.IPv6DefaultRoute),
+		NextHops: nhsV6Default,
+	})
+
+	var routingtables topology.RoutingTables
+	routingtables = append(routingtables, &topology.RoutingTable{
+		ID:     255,
+		Routes: routes,
+	})
+
+	m1 := graph.Metadata{
+		"RoutingTables": &routingtables,
+	}
+
+	n, _ := g.NewNode(graph.GenID(), m1)
+
+	res := execNextHopQuery(t, g, "g.v().NextHop('8.8.8.8')")
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 result, returned: %v", res.Values())
+	}
+	if _, ok := res.Values()[0].(map[string]*topology.NextHop)[string(n.ID)]; ok {
+		t.Fatalf("IPv4 destination should not match the IPv6 default route")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package topology
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+)
+
+// Neighbor describes a L2/L3 neighbor entry, as found in the ARP/NDP cache
+// of a node, that maps a next hop IP to a MAC address reachable through a
+// given interface.
+//
+// easyjson:json
+// gendecoder
+type Neighbor struct {
+	IP      netip.Addr `json:"IP"`
+	IfIndex int64      `json:"IfIndex"`
+	MAC     string     `json:"MAC"`
+}
+
+// Neighbors is a list of Neighbor
+//
+// easyjson:json
+// gendecoder
+type Neighbors []*Neighbor
+
+// NextHop describes the next hop of a Route, either an IP reachable through
+// IfIndex or, for a directly connected route, just IfIndex.
+//
+// easyjson:json
+// gendecoder
+type NextHop struct {
+	IP      netip.Addr `json:"IP"`
+	IfIndex int64      `json:"IfIndex"`
+}
+
+// MarshalJSON marshals a NextHop, omitting IP when it isn't set, e.g. for a
+// directly connected route. netip.Addr is a struct, so an "omitempty" struct
+// tag would have no effect on it; this preserves the wire format from when
+// IP was a net.IP, where a nil IP was dropped by "omitempty".
+func (n NextHop) MarshalJSON() ([]byte, error) {
+	if !n.IP.IsValid() {
+		return json.Marshal(struct {
+			IfIndex int64 `json:"IfIndex"`
+		}{IfIndex: n.IfIndex})
+	}
+	return json.Marshal(struct {
+		IP      netip.Addr `json:"IP"`
+		IfIndex int64      `json:"IfIndex"`
+	}{IP: n.IP, IfIndex: n.IfIndex})
+}
+
+// Route describes a routing table entry, a Prefix associated with the list
+// of NextHops that can be used to reach it.
+//
+// easyjson:json
+// gendecoder
+type Route struct {
+	Prefix   Prefix     `json:"Prefix"`
+	NextHops []*NextHop `json:"NextHops,omitempty"`
+}
+
+// RoutingTable describes a Linux routing table, identified by its ID, the
+// same ID as used by "ip route show table <ID>".
+//
+// easyjson:json
+// gendecoder
+type RoutingTable struct {
+	ID     int64    `json:"ID"`
+	Src    net.IP   `json:"Src,omitempty"`
+	Routes []*Route `json:"Routes,omitempty"`
+}
+
+// RoutingTables is a list of RoutingTable
+//
+// easyjson:json
+// gendecoder
+type RoutingTables []*RoutingTable
+
+// RoutingRule describes a policy routing rule, as found in "ip rule show":
+// a selector (source prefix and/or incoming interface and/or fwmark) that,
+// when matched, directs the lookup to Table instead of the main routing
+// table. Rules are evaluated in ascending Priority order, the same as the
+// kernel does.
+//
+// easyjson:json
+// gendecoder
+type RoutingRule struct {
+	Priority int64   `json:"Priority"`
+	Src      *Prefix `json:"Src,omitempty"`
+	IifIndex int64   `json:"IifIndex,omitempty"`
+	FwMark   uint32  `json:"FwMark,omitempty"`
+	Table    int64   `json:"Table"`
+}
+
+// RoutingRules is a list of RoutingRule
+//
+// easyjson:json
+// gendecoder
+type RoutingRules []*RoutingRule
+
+// Prefix is a wrapper around netip.Prefix so that it can be (un)marshalled
+// to and from its CIDR string representation, e.g. "192.168.0.0/24", the
+// same wire format used when Prefix was backed by net.IPNet.
+type Prefix netip.Prefix
+
+// IPv4DefaultRoute is the IPv4 default route, 0.0.0.0/0
+var IPv4DefaultRoute = netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+
+// IPv6DefaultRoute is the IPv6 default route, ::/0
+var IPv6DefaultRoute = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+
+// MarshalJSON marshals a Prefix into its CIDR string representation
+func (p Prefix) MarshalJSON() ([]byte, error) {
+	prefix := netip.Prefix(p)
+	if !prefix.IsValid() {
+		return []byte(`""`), nil
+	}
+	return []byte(`"` + prefix.String() + `"`), nil
+}
+
+// UnmarshalJSON unmarshals a CIDR string representation into a Prefix. It
+// accepts both the current and the pre-netip wire format, since both encode
+// a prefix as its plain CIDR string.
+func (p *Prefix) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*p = Prefix{}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*p = Prefix{}
+		return nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return err
+	}
+
+	*p = Prefix(prefix)
+	return nil
+}
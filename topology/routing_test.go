@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package topology
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+func TestNextHopMarshalJSONDirectlyConnected(t *testing.T) {
+	nh := &NextHop{IfIndex: 5}
+
+	b, err := json.Marshal(nh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(b), `{"IfIndex":5}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextHopMarshalJSONWithGateway(t *testing.T) {
+	nh := &NextHop{IP: netip.MustParseAddr("10.0.0.1"), IfIndex: 5}
+
+	b, err := json.Marshal(nh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(b), `{"IP":"10.0.0.1","IfIndex":5}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestPrefixUnmarshalJSONNull(t *testing.T) {
+	var p Prefix
+	if err := json.Unmarshal([]byte("null"), &p); err != nil {
+		t.Fatal(err)
+	}
+	if netip.Prefix(p).IsValid() {
+		t.Errorf("expected a zero-value Prefix, got %v", p)
+	}
+}
+
+func TestPrefixUnmarshalJSONMalformed(t *testing.T) {
+	var p Prefix
+	if err := json.Unmarshal([]byte("5"), &p); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestPrefixUnmarshalJSONValid(t *testing.T) {
+	var p Prefix
+	if err := json.Unmarshal([]byte(`"192.168.0.0/24"`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := netip.Prefix(p).String(), "192.168.0.0/24"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}